@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointWriter tracks which URLs have completed and periodically
+// flushes that set to disk so a crashed run can resume without refetching.
+type checkpointWriter struct {
+	path      string
+	mutex     sync.Mutex
+	completed map[string]bool
+}
+
+func newCheckpointWriter(path string) *checkpointWriter {
+	return &checkpointWriter{path: path, completed: make(map[string]bool)}
+}
+
+func (c *checkpointWriter) mark(url string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.completed[url] = true
+}
+
+// flush rewrites the checkpoint file with the current completed set, one
+// URL per line, via a temp-file-and-rename so a crash mid-write can't leave
+// a truncated checkpoint behind.
+func (c *checkpointWriter) flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	temp, e := os.CreateTemp(filepath.Dir(c.path), "raven-checkpoint-*")
+
+	if e != nil {
+		return e
+	}
+
+	writer := bufio.NewWriter(temp)
+
+	for url := range c.completed {
+		if _, e := writer.WriteString(url + "\n"); e != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+			return e
+		}
+	}
+
+	if e := writer.Flush(); e != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return e
+	}
+
+	if e := temp.Close(); e != nil {
+		os.Remove(temp.Name())
+		return e
+	}
+
+	return os.Rename(temp.Name(), c.path)
+}
+
+// periodicFlush calls flush on the given interval until stop is closed.
+func (c *checkpointWriter) periodicFlush(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// loadCheckpoint reads a previously flushed checkpoint file, returning the
+// set of URLs it recorded as completed.
+func loadCheckpoint(path string) (map[string]bool, error) {
+	file, e := os.Open(path)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer file.Close()
+
+	completed := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			completed[line] = true
+		}
+	}
+
+	return completed, scanner.Err()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SourceDecoder turns a stream of raw input into a channel of URL strings,
+// stopping once max records have been emitted (a negative max means no
+// limit).
+type SourceDecoder interface {
+	decode(source io.Reader, max int) <-chan string
+}
+
+// selectDecoder picks a SourceDecoder for filename, honoring an explicit
+// format override before falling back to the file extension.
+func selectDecoder(format string, filename string, urlField string, urlColumn string) (SourceDecoder, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+
+	switch format {
+	case "jsonl", "ndjson", "json":
+		return &jsonlDecoder{field: urlField}, nil
+	case "csv":
+		return &csvDecoder{column: urlColumn}, nil
+	case "xml", "sitemap":
+		return &sitemapDecoder{}, nil
+	case "", "txt", "list", "lines":
+		return &lineDecoder{}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized format %q", format)
+	}
+}
+
+// lineDecoder treats every non-empty, non-comment line as a URL.
+type lineDecoder struct{}
+
+func (d *lineDecoder) decode(source io.Reader, max int) <-chan string {
+	out := make(chan string, 100)
+
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(source)
+		count := 0
+
+		for scanner.Scan() {
+			if max >= 0 && count >= max {
+				return
+			}
+
+			line := strings.TrimSpace(scanner.Text())
+
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			count += 1
+			out <- line
+		}
+	}()
+
+	return out
+}
+
+// jsonlDecoder reads newline-delimited JSON objects, pulling the URL out of
+// a configurable dotted field path (e.g. "meta.url").
+type jsonlDecoder struct {
+	field string
+}
+
+func (d *jsonlDecoder) decode(source io.Reader, max int) <-chan string {
+	out := make(chan string, 100)
+
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(source)
+		count := 0
+
+		for scanner.Scan() {
+			if max >= 0 && count >= max {
+				return
+			}
+
+			line := strings.TrimSpace(scanner.Text())
+
+			if line == "" {
+				continue
+			}
+
+			var record map[string]interface{}
+
+			if e := json.Unmarshal([]byte(line), &record); e != nil {
+				fmt.Printf("skipping invalid jsonl line: %v\n", e)
+				continue
+			}
+
+			value, ok := lookupField(record, d.field)
+
+			if !ok {
+				fmt.Printf("skipping line, field %q not found\n", d.field)
+				continue
+			}
+
+			count += 1
+			out <- value
+		}
+	}()
+
+	return out
+}
+
+// lookupField walks a dotted path (e.g. "meta.url") through nested JSON
+// objects, returning the string found at that path.
+func lookupField(record map[string]interface{}, path string) (string, bool) {
+	var current interface{} = record
+
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+
+		if !ok {
+			return "", false
+		}
+
+		current, ok = object[part]
+
+		if !ok {
+			return "", false
+		}
+	}
+
+	value, ok := current.(string)
+	return value, ok
+}
+
+// csvDecoder reads URLs out of a named or positional column. The header row
+// is always consumed; if its column name doesn't match, column is parsed as
+// a 0-based index instead.
+type csvDecoder struct {
+	column string
+}
+
+func (d *csvDecoder) decode(source io.Reader, max int) <-chan string {
+	out := make(chan string, 100)
+
+	go func() {
+		defer close(out)
+		reader := csv.NewReader(source)
+		reader.FieldsPerRecord = -1
+
+		header, e := reader.Read()
+
+		if e != nil {
+			return
+		}
+
+		index := indexOf(header, d.column)
+
+		if index < 0 {
+			parsed, e := strconv.Atoi(d.column)
+
+			if e != nil {
+				fmt.Printf("url column %q not found in csv header\n", d.column)
+				return
+			}
+
+			index = parsed
+		}
+
+		count := 0
+
+		for {
+			if max >= 0 && count >= max {
+				return
+			}
+
+			record, e := reader.Read()
+
+			if e == io.EOF {
+				return
+			}
+
+			if e != nil {
+				fmt.Printf("skipping invalid csv row: %v\n", e)
+				continue
+			}
+
+			if index >= len(record) {
+				continue
+			}
+
+			count += 1
+			out <- strings.TrimSpace(record[index])
+		}
+	}()
+
+	return out
+}
+
+func indexOf(header []string, column string) int {
+	for i, name := range header {
+		if strings.EqualFold(name, column) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sitemapDecoder streams <url><loc>...</loc></url> entries out of an XML
+// sitemap without buffering the whole document.
+type sitemapDecoder struct{}
+
+func (d *sitemapDecoder) decode(source io.Reader, max int) <-chan string {
+	out := make(chan string, 100)
+
+	go func() {
+		defer close(out)
+		decoder := xml.NewDecoder(source)
+		count := 0
+		inLoc := false
+
+		for {
+			if max >= 0 && count >= max {
+				return
+			}
+
+			token, e := decoder.Token()
+
+			if e != nil {
+				return
+			}
+
+			switch element := token.(type) {
+			case xml.StartElement:
+				inLoc = element.Name.Local == "loc"
+			case xml.CharData:
+				if inLoc {
+					if value := strings.TrimSpace(string(element)); value != "" {
+						count += 1
+						out <- value
+					}
+				}
+			case xml.EndElement:
+				if element.Name.Local == "loc" {
+					inLoc = false
+				}
+			}
+		}
+	}()
+
+	return out
+}
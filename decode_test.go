@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func drain(out <-chan string) []string {
+	values := make([]string, 0)
+
+	for value := range out {
+		values = append(values, value)
+	}
+
+	return values
+}
+
+func TestLineDecoderSkipsBlankAndCommentLines(t *testing.T) {
+	source := strings.NewReader("https://a.test\n\n# comment\nhttps://b.test\n")
+	values := drain((&lineDecoder{}).decode(source, -1))
+
+	if len(values) != 2 || values[0] != "https://a.test" || values[1] != "https://b.test" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestLineDecoderRespectsMax(t *testing.T) {
+	source := strings.NewReader("https://a.test\nhttps://b.test\nhttps://c.test\n")
+	values := drain((&lineDecoder{}).decode(source, 2))
+
+	if len(values) != 2 {
+		t.Fatalf("expected max to cap output at 2, got %v", values)
+	}
+}
+
+func TestJSONLDecoderLooksUpDottedField(t *testing.T) {
+	source := strings.NewReader(`{"meta": {"url": "https://a.test"}}` + "\n" + `{"meta": {"url": "https://b.test"}}` + "\n")
+	values := drain((&jsonlDecoder{field: "meta.url"}).decode(source, -1))
+
+	if len(values) != 2 || values[0] != "https://a.test" || values[1] != "https://b.test" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestJSONLDecoderSkipsMissingFieldAndInvalidJSON(t *testing.T) {
+	source := strings.NewReader(`not json` + "\n" + `{"url": "https://a.test"}` + "\n" + `{"other": "field"}` + "\n")
+	values := drain((&jsonlDecoder{field: "url"}).decode(source, -1))
+
+	if len(values) != 1 || values[0] != "https://a.test" {
+		t.Fatalf("expected only the valid record with the field present, got %v", values)
+	}
+}
+
+func TestCSVDecoderFindsColumnByName(t *testing.T) {
+	source := strings.NewReader("id,url\n1,https://a.test\n2,https://b.test\n")
+	values := drain((&csvDecoder{column: "url"}).decode(source, -1))
+
+	if len(values) != 2 || values[0] != "https://a.test" || values[1] != "https://b.test" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestCSVDecoderFallsBackToPositionalIndex(t *testing.T) {
+	source := strings.NewReader("id,link\n1,https://a.test\n")
+	values := drain((&csvDecoder{column: "1"}).decode(source, -1))
+
+	if len(values) != 1 || values[0] != "https://a.test" {
+		t.Fatalf("expected positional column lookup, got %v", values)
+	}
+}
+
+func TestSitemapDecoderExtractsLocEntries(t *testing.T) {
+	source := strings.NewReader(`<?xml version="1.0"?><urlset><url><loc>https://a.test</loc></url><url><loc>https://b.test</loc></url></urlset>`)
+	values := drain((&sitemapDecoder{}).decode(source, -1))
+
+	if len(values) != 2 || values[0] != "https://a.test" || values[1] != "https://b.test" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestSelectDecoderInfersFromExtension(t *testing.T) {
+	for _, filename := range []string{"input.jsonl", "input.csv", "input.xml", "input.txt"} {
+		if decoder, e := selectDecoder("", filename, "url", "url"); e != nil || decoder == nil {
+			t.Errorf("expected a decoder for %s, got error: %v", filename, e)
+		}
+	}
+}
+
+func TestSelectDecoderRejectsUnknownFormat(t *testing.T) {
+	if _, e := selectDecoder("", "input.unknown", "url", "url"); e == nil {
+		t.Fatalf("expected an error selecting a decoder for an unrecognized extension")
+	}
+}
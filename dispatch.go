@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostDispatcher is the single gate in front of every outbound request: it
+// owns the pool of reusable clients, caps how many requests are in flight
+// per host, applies a global QPS limit, and honors robots.txt crawl-delay
+// and disallow rules. Replacing the old bare chan *http.Client with this
+// stops raven from hammering any one host with all of its workers.
+type hostDispatcher struct {
+	clients            chan *http.Client
+	perHostConcurrency int
+	ignoreRobots       bool
+	limiter            *tokenBucket
+	robotsClient       *http.Client
+
+	mutex  sync.Mutex
+	hosts  map[string]*hostState
+	robots map[string]*robotsEntry
+}
+
+type hostState struct {
+	semaphore   chan struct{}
+	mutex       sync.Mutex
+	lastRequest time.Time
+}
+
+func newHostDispatcher(concurrency int, perHostConcurrency int, rate float64, ignoreRobots bool) *hostDispatcher {
+	clients := make(chan *http.Client, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		clients <- &http.Client{}
+	}
+
+	return &hostDispatcher{
+		clients:            clients,
+		perHostConcurrency: perHostConcurrency,
+		ignoreRobots:       ignoreRobots,
+		limiter:            newTokenBucket(rate),
+		robotsClient:       &http.Client{Timeout: 5 * time.Second},
+		hosts:              make(map[string]*hostState),
+		robots:             make(map[string]*robotsEntry),
+	}
+}
+
+func (d *hostDispatcher) stateFor(host string) *hostState {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state, ok := d.hosts[host]
+
+	if !ok {
+		state = &hostState{}
+
+		if d.perHostConcurrency > 0 {
+			state.semaphore = make(chan struct{}, d.perHostConcurrency)
+		}
+
+		d.hosts[host] = state
+	}
+
+	return state
+}
+
+// acquire blocks for a free per-host concurrency slot, then a global client
+// slot, the global rate limit, and any robots.txt crawl-delay owed to the
+// host, then returns the client to use and a func to release the slots.
+// The per-host slot is claimed first and the global client last, so a
+// backlog queued behind one host's saturated semaphore never ties up
+// clients that other, uncontended hosts are waiting on.
+func (d *hostDispatcher) acquire(resource *url.URL) (*http.Client, func()) {
+	state := d.stateFor(resource.Host)
+
+	if state.semaphore != nil {
+		state.semaphore <- struct{}{}
+	}
+
+	client := <-d.clients
+
+	d.limiter.take()
+
+	if !d.ignoreRobots {
+		d.waitForCrawlDelay(resource, state)
+	}
+
+	return client, func() {
+		d.clients <- client
+
+		if state.semaphore != nil {
+			<-state.semaphore
+		}
+	}
+}
+
+func (d *hostDispatcher) waitForCrawlDelay(resource *url.URL, state *hostState) {
+	rules := d.robotsFor(resource)
+
+	if rules == nil || rules.crawlDelay <= 0 {
+		return
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if elapsed := time.Since(state.lastRequest); elapsed < rules.crawlDelay {
+		time.Sleep(rules.crawlDelay - elapsed)
+	}
+
+	state.lastRequest = time.Now()
+}
+
+// disallowed reports whether resource's path is blocked by the cached
+// robots.txt rules for its host.
+func (d *hostDispatcher) disallowed(resource *url.URL) bool {
+	if d.ignoreRobots {
+		return false
+	}
+
+	rules := d.robotsFor(resource)
+
+	return rules != nil && rules.disallows(resource.Path)
+}
+
+// robotsEntry guards a single host's robots.txt fetch with a sync.Once, so
+// concurrent workers racing a brand-new host converge on one request
+// instead of each kicking off its own.
+type robotsEntry struct {
+	once  sync.Once
+	rules *robotsRules
+}
+
+func (d *hostDispatcher) robotsFor(resource *url.URL) *robotsRules {
+	d.mutex.Lock()
+	entry, ok := d.robots[resource.Host]
+
+	if !ok {
+		entry = &robotsEntry{}
+		d.robots[resource.Host] = entry
+	}
+
+	d.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.rules = fetchRobots(d.robotsClient, resource)
+	})
+
+	return entry.rules
+}
+
+type robotsRules struct {
+	disallow   []string
+	crawlDelay time.Duration
+}
+
+func (r *robotsRules) disallows(path string) bool {
+	for _, prefix := range r.disallow {
+		if prefix != "" && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetchRobots retrieves and parses /robots.txt for resource's host, scoped
+// to the "*" user-agent group. A fetch failure yields empty (permissive)
+// rules rather than blocking the crawl.
+func fetchRobots(client *http.Client, resource *url.URL) *robotsRules {
+	target := &url.URL{Scheme: resource.Scheme, Host: resource.Host, Path: "/robots.txt"}
+	rules := &robotsRules{}
+
+	response, e := client.Get(target.String())
+
+	if e != nil {
+		return rules
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode > 299 {
+		return rules
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	applies := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := splitDirective(line)
+
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "user-agent":
+			applies = value == "*"
+		case "disallow":
+			if applies && value != "" {
+				rules.disallow = append(rules.disallow, value)
+			}
+		case "crawl-delay":
+			if applies {
+				if seconds, e := strconv.ParseFloat(value, 64); e == nil {
+					rules.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	return rules
+}
+
+func splitDirective(line string) (string, string, bool) {
+	parts := strings.SplitN(line, ":", 2)
+
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
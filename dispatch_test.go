@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSplitDirective(t *testing.T) {
+	if key, value, ok := splitDirective("Disallow: /private"); !ok || key != "Disallow" || value != "/private" {
+		t.Fatalf("unexpected parse: %q %q %v", key, value, ok)
+	}
+
+	if _, _, ok := splitDirective("not-a-directive"); ok {
+		t.Fatalf("expected a line without a colon to fail to parse")
+	}
+}
+
+func TestRobotsRulesDisallows(t *testing.T) {
+	rules := &robotsRules{disallow: []string{"/private", "/admin"}}
+
+	if !rules.disallows("/private/data") {
+		t.Fatalf("expected /private/data to be disallowed")
+	}
+
+	if rules.disallows("/public") {
+		t.Fatalf("expected /public to be allowed")
+	}
+}
+
+func TestFetchRobotsParsesWildcardGroup(t *testing.T) {
+	body := "User-agent: other\nDisallow: /everything\n\nUser-agent: *\nDisallow: /private\nCrawl-delay: 2\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	resource, _ := url.Parse(server.URL + "/some/page")
+	rules := fetchRobots(server.Client(), resource)
+
+	if !rules.disallows("/private") {
+		t.Fatalf("expected /private to be disallowed by the wildcard group")
+	}
+
+	if rules.disallows("/everything") {
+		t.Fatalf("did not expect rules scoped to another user-agent to apply")
+	}
+
+	if rules.crawlDelay != 2*time.Second {
+		t.Fatalf("expected a 2s crawl-delay, got %s", rules.crawlDelay)
+	}
+}
+
+func TestFetchRobotsPermissiveOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	resource, _ := url.Parse(server.URL + "/page")
+	rules := fetchRobots(server.Client(), resource)
+
+	if rules.disallows("/anything") {
+		t.Fatalf("expected a missing robots.txt to yield permissive rules")
+	}
+}
+
+func TestHostDispatcherDoesNotStrandGlobalCapacityOnBusyHost(t *testing.T) {
+	d := newHostDispatcher(4, 1, 0, true)
+
+	hostA, _ := url.Parse("http://host-a.test/1")
+	hostB, _ := url.Parse("http://host-b.test/1")
+
+	_, releaseA := d.acquire(hostA)
+	defer releaseA()
+
+	// Queue 3 more goroutines behind host A's saturated per-host
+	// semaphore. If acquire() still took a global client before the
+	// per-host slot, each of these would strand one of the 4 global
+	// clients for as long as they're queued.
+	for i := 0; i < 3; i++ {
+		go d.acquire(hostA)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+
+	go func() {
+		_, release := d.acquire(hostB)
+		release()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire(hostB) blocked even though host B has no contention")
+	}
+}
+
+func TestHostDispatcherPerHostSemaphoreSerializes(t *testing.T) {
+	d := newHostDispatcher(4, 1, 0, true)
+	host, _ := url.Parse("http://host-a.test/1")
+
+	_, first := d.acquire(host)
+
+	acquired := make(chan struct{})
+
+	go func() {
+		_, release := d.acquire(host)
+		close(acquired)
+		release()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("expected the second acquire to block while the per-host slot is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the second acquire to proceed once the per-host slot was released")
+	}
+}
+
+func TestTokenBucketDisabledWhenRateNonPositive(t *testing.T) {
+	bucket := newTokenBucket(0)
+
+	done := make(chan struct{})
+
+	go func() {
+		bucket.take()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected take() to return immediately when rate limiting is disabled")
+	}
+}
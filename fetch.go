@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type resultProcessor struct {
+	results        chan<- *ravenResult
+	dispatcher     *hostDispatcher
+	requestTimeout time.Duration
+	maxRetries     int
+	backoffBase    time.Duration
+	backoffMax     time.Duration
+	maxBodyBytes   int64
+}
+
+type ravenResult struct {
+	url          string
+	completed    bool
+	status       int
+	exception    error
+	size         int
+	headerSize   int
+	measuredSize int
+	sizeMismatch bool
+	ambiguous    bool
+	retries      int
+	latency      time.Duration
+}
+
+func (p *resultProcessor) fetch(resource *url.URL, group *sync.WaitGroup) {
+	defer group.Done()
+
+	if p.dispatcher.disallowed(resource) {
+		err := fmt.Errorf("disallowed by robots.txt: %s", resource)
+		p.results <- &ravenResult{url: resource.String(), completed: true, exception: err}
+		return
+	}
+
+	started := time.Now()
+	retries := 0
+
+	for {
+		client, release := p.dispatcher.acquire(resource)
+		result, retryAfter, retryable := p.attempt(client, resource)
+		release()
+
+		if !retryable || retries >= p.maxRetries {
+			result.retries = retries
+			result.latency = time.Since(started)
+			p.results <- result
+			return
+		}
+
+		retries += 1
+		time.Sleep(backoffDuration(p.backoffBase, p.backoffMax, retries, retryAfter))
+	}
+}
+
+// attempt performs a single fetch of resource and reports whether the
+// outcome is worth retrying, along with any server-requested delay before
+// the next attempt (from a Retry-After header). It issues a HEAD first and
+// only falls back to a streamed GET when the server doesn't tell us the
+// size up front.
+func (p *resultProcessor) attempt(client *http.Client, resource *url.URL) (*ravenResult, time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout)
+	defer cancel()
+
+	headRequest, e := http.NewRequestWithContext(ctx, http.MethodHead, resource.String(), nil)
+
+	if e != nil {
+		return &ravenResult{url: resource.String(), completed: true, exception: e}, 0, false
+	}
+
+	headResponse, e := client.Do(headRequest)
+
+	if e != nil {
+		return &ravenResult{url: resource.String(), completed: true, exception: e}, 0, true
+	}
+
+	headResponse.Body.Close()
+
+	if headResponse.StatusCode > 399 {
+		return p.statusError(resource, headResponse)
+	}
+
+	if length, ok := contentLength(headResponse); ok {
+		result := &ravenResult{
+			url:        resource.String(),
+			completed:  true,
+			status:     headResponse.StatusCode,
+			size:       length,
+			headerSize: length,
+		}
+		return result, 0, false
+	}
+
+	return p.measure(ctx, client, resource)
+}
+
+// measure falls back to a GET request, streaming the response body through
+// a counting writer so the true size can be recorded even when the server
+// omits Content-Length or sends a chunked response. When the GET response
+// does declare a Content-Length, the result flags sizeMismatch if it
+// disagrees with the number of bytes actually read.
+func (p *resultProcessor) measure(ctx context.Context, client *http.Client, resource *url.URL) (*ravenResult, time.Duration, bool) {
+	request, e := http.NewRequestWithContext(ctx, http.MethodGet, resource.String(), nil)
+
+	if e != nil {
+		return &ravenResult{url: resource.String(), completed: true, exception: e}, 0, false
+	}
+
+	response, e := client.Do(request)
+
+	if e != nil {
+		return &ravenResult{url: resource.String(), completed: true, exception: e}, 0, true
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode > 399 {
+		return p.statusError(resource, response)
+	}
+
+	declared, declaredOK := contentLength(response)
+
+	counter := &countingWriter{}
+	body := io.Reader(response.Body)
+
+	if p.maxBodyBytes > 0 {
+		body = io.LimitReader(response.Body, p.maxBodyBytes+1)
+	}
+
+	if _, e := io.Copy(counter, body); e != nil {
+		return &ravenResult{url: resource.String(), completed: true, exception: e, headerSize: declared, ambiguous: true}, 0, true
+	}
+
+	if p.maxBodyBytes > 0 && counter.n > p.maxBodyBytes {
+		err := fmt.Errorf("response exceeded max-body-bytes (%d): %s", p.maxBodyBytes, resource)
+		result := &ravenResult{url: resource.String(), completed: true, exception: err, headerSize: declared, measuredSize: int(counter.n), ambiguous: true}
+		return result, 0, false
+	}
+
+	result := &ravenResult{
+		url:          resource.String(),
+		completed:    true,
+		status:       response.StatusCode,
+		size:         int(counter.n),
+		headerSize:   declared,
+		measuredSize: int(counter.n),
+		sizeMismatch: declaredOK && declared != int(counter.n),
+	}
+	return result, 0, false
+}
+
+// statusError converts a non-2xx/3xx response into a ravenResult, honoring
+// Retry-After and classifying whether the status is worth retrying.
+func (p *resultProcessor) statusError(resource *url.URL, response *http.Response) (*ravenResult, time.Duration, bool) {
+	retryAfter, _ := parseRetryAfter(response.Header.Get("Retry-After"))
+	err := fmt.Errorf("invalid status code: %d", response.StatusCode)
+	result := &ravenResult{url: resource.String(), completed: true, status: response.StatusCode, exception: err}
+	return result, retryAfter, isRetryableStatus(response.StatusCode)
+}
+
+// contentLength reports the declared body size of response, and false when
+// the length can't be trusted up front (missing header or a chunked
+// transfer encoding).
+func contentLength(response *http.Response) (int, bool) {
+	if isChunked(response) {
+		return 0, false
+	}
+
+	value := response.Header.Get("Content-Length")
+
+	if value == "" {
+		return 0, false
+	}
+
+	length, e := strconv.Atoi(value)
+
+	if e != nil {
+		return 0, false
+	}
+
+	return length, true
+}
+
+func isChunked(response *http.Response) bool {
+	if strings.EqualFold(response.Header.Get("Transfer-Encoding"), "chunked") {
+		return true
+	}
+
+	for _, encoding := range response.TransferEncoding {
+		if strings.EqualFold(encoding, "chunked") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// countingWriter discards everything written to it while tallying the
+// number of bytes seen, letting us measure a response body via io.Copy
+// without holding it in memory.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// isRetryableStatus reports whether an HTTP status code is worth retrying:
+// request timeouts, rate limiting, and server errors, but not the rest of
+// the 4xx range.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// parseRetryAfter understands both the delay-seconds and HTTP-date forms of
+// the Retry-After header.
+func parseRetryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, e := strconv.Atoi(header); e == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, e := http.ParseTime(header); e == nil {
+		if d := time.Until(when); d > 0 {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
+
+// backoffDuration computes the delay before the next retry attempt, honoring
+// a server-requested Retry-After when present and otherwise applying
+// exponential backoff with jitter, capped at max.
+func backoffDuration(base, max time.Duration, attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > max {
+			return max
+		}
+
+		return retryAfter
+	}
+
+	scaled := base * time.Duration(int64(1)<<uint(attempt-1))
+
+	if scaled <= 0 || scaled > max {
+		scaled = max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(scaled/2) + 1))
+	return scaled/2 + jitter
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffDurationUsesRetryAfter(t *testing.T) {
+	base := 100 * time.Millisecond
+	max := 10 * time.Second
+
+	if d := backoffDuration(base, max, 1, 2*time.Second); d != 2*time.Second {
+		t.Fatalf("expected retry-after to win, got %s", d)
+	}
+}
+
+func TestBackoffDurationCapsRetryAfterAtMax(t *testing.T) {
+	base := 100 * time.Millisecond
+	max := 1 * time.Second
+
+	if d := backoffDuration(base, max, 1, 5*time.Second); d != max {
+		t.Fatalf("expected retry-after to be capped at max, got %s", d)
+	}
+}
+
+func TestBackoffDurationExponentialWithinBounds(t *testing.T) {
+	base := 100 * time.Millisecond
+	max := 10 * time.Second
+
+	for attempt := 1; attempt <= 6; attempt++ {
+		d := backoffDuration(base, max, attempt, 0)
+
+		if d < 0 || d > max {
+			t.Fatalf("attempt %d produced out-of-bounds backoff %s", attempt, d)
+		}
+	}
+}
+
+func TestParseRetryAfterDelaySeconds(t *testing.T) {
+	d, ok := parseRetryAfter("5")
+
+	if !ok || d != 5*time.Second {
+		t.Fatalf("expected 5s, ok=true, got %s, ok=%v", d, ok)
+	}
+}
+
+func TestParseRetryAfterHTTPDate(t *testing.T) {
+	when := time.Now().Add(30 * time.Second).UTC()
+	d, ok := parseRetryAfter(when.Format(http.TimeFormat))
+
+	if !ok {
+		t.Fatalf("expected an HTTP-date Retry-After to parse")
+	}
+
+	if d <= 0 || d > 31*time.Second {
+		t.Fatalf("expected a delay of roughly 30s, got %s", d)
+	}
+}
+
+func TestParseRetryAfterEmptyOrInvalid(t *testing.T) {
+	if _, ok := parseRetryAfter(""); ok {
+		t.Fatalf("expected empty header to not parse")
+	}
+
+	if _, ok := parseRetryAfter("not-a-date"); ok {
+		t.Fatalf("expected garbage header to not parse")
+	}
+
+	if _, ok := parseRetryAfter("-5"); ok {
+		t.Fatalf("expected negative delay-seconds to not parse")
+	}
+}
+
+func TestContentLengthTrustsValidHeader(t *testing.T) {
+	response := &http.Response{Header: http.Header{"Content-Length": []string{"1024"}}}
+
+	length, ok := contentLength(response)
+
+	if !ok || length != 1024 {
+		t.Fatalf("expected 1024, true, got %d, %v", length, ok)
+	}
+}
+
+func TestContentLengthMissingOrInvalid(t *testing.T) {
+	if _, ok := contentLength(&http.Response{Header: http.Header{}}); ok {
+		t.Fatalf("expected missing Content-Length to be untrusted")
+	}
+
+	invalid := &http.Response{Header: http.Header{"Content-Length": []string{"not-a-number"}}}
+
+	if _, ok := contentLength(invalid); ok {
+		t.Fatalf("expected unparseable Content-Length to be untrusted")
+	}
+}
+
+func TestContentLengthIgnoresChunkedTransferEncoding(t *testing.T) {
+	response := &http.Response{
+		Header:           http.Header{"Content-Length": []string{"1024"}, "Transfer-Encoding": []string{"chunked"}},
+		TransferEncoding: []string{"chunked"},
+	}
+
+	if _, ok := contentLength(response); ok {
+		t.Fatalf("expected a chunked response to never trust Content-Length")
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	retryable := []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway}
+
+	for _, code := range retryable {
+		if !isRetryableStatus(code) {
+			t.Errorf("expected status %d to be retryable", code)
+		}
+	}
+
+	notRetryable := []int{http.StatusOK, http.StatusNotFound, http.StatusForbidden, http.StatusBadRequest}
+
+	for _, code := range notRetryable {
+		if isRetryableStatus(code) {
+			t.Errorf("expected status %d to not be retryable", code)
+		}
+	}
+}
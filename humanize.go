@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/montanaflynn/stats"
+)
+
+// humanizeBytes formats n as a human-scaled byte size (KB/MB/GB/...)
+// instead of a raw byte count.
+func humanizeBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := n
+	unit := 0
+
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit += 1
+	}
+
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
+
+// parsePercentiles turns a comma-separated string like "50,90,95,99.9" into
+// the percentiles it names, skipping any entries that don't parse.
+func parsePercentiles(raw string) []float64 {
+	percentiles := make([]float64, 0)
+
+	for _, part := range strings.Split(raw, ",") {
+		value, e := strconv.ParseFloat(strings.TrimSpace(part), 64)
+
+		if e != nil {
+			continue
+		}
+
+		percentiles = append(percentiles, value)
+	}
+
+	return percentiles
+}
+
+// sizeHistogram renders a log2-bucketed text histogram of sizes so a user
+// can eyeball the distribution without plotting tools.
+func sizeHistogram(sizes stats.Float64Data) string {
+	if len(sizes) == 0 {
+		return "size histogram: (no data)"
+	}
+
+	buckets := make(map[int]int)
+	maxBucket := 0
+
+	for _, size := range sizes {
+		bucket := 0
+
+		if size >= 1 {
+			bucket = int(math.Log2(size))
+		}
+
+		buckets[bucket] += 1
+
+		if bucket > maxBucket {
+			maxBucket = bucket
+		}
+	}
+
+	lines := []string{"size histogram (log2 buckets):"}
+
+	for bucket := 0; bucket <= maxBucket; bucket++ {
+		count := buckets[bucket]
+
+		if count == 0 {
+			continue
+		}
+
+		lower := math.Pow(2, float64(bucket))
+		upper := math.Pow(2, float64(bucket+1))
+		bar := strings.Repeat("#", count)
+		lines = append(lines, fmt.Sprintf("  [%s, %s) %s (%d)", humanizeBytes(lower), humanizeBytes(upper), bar, count))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/montanaflynn/stats"
+)
+
+func TestHumanizeBytesScalesUnits(t *testing.T) {
+	cases := map[float64]string{
+		0:                  "0.00B",
+		512:                "512.00B",
+		1024:               "1.00KB",
+		1536:               "1.50KB",
+		1024 * 1024:        "1.00MB",
+		1024 * 1024 * 5:    "5.00MB",
+		1024 * 1024 * 1024: "1.00GB",
+	}
+
+	for input, expected := range cases {
+		if got := humanizeBytes(input); got != expected {
+			t.Errorf("humanizeBytes(%v) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestParsePercentilesSkipsInvalidEntries(t *testing.T) {
+	got := parsePercentiles("50, 90,not-a-number,99.9")
+	want := []float64{50, 90, 99.9}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestParsePercentilesEmptyString(t *testing.T) {
+	if got := parsePercentiles(""); len(got) != 0 {
+		t.Fatalf("expected no percentiles from an empty string, got %v", got)
+	}
+}
+
+func TestSizeHistogramEmpty(t *testing.T) {
+	if got := sizeHistogram(stats.Float64Data{}); got != "size histogram: (no data)" {
+		t.Fatalf("unexpected output for empty sizes: %q", got)
+	}
+}
+
+func TestSizeHistogramBucketsBySize(t *testing.T) {
+	got := sizeHistogram(stats.Float64Data{10, 20, 2000})
+
+	if !strings.Contains(got, "size histogram (log2 buckets):") {
+		t.Fatalf("expected a histogram header, got %q", got)
+	}
+
+	if strings.Count(got, "#") != 3 {
+		t.Fatalf("expected one bar character per sample, got %q", got)
+	}
+}
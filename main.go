@@ -1,21 +1,36 @@
 package main
 
 import "os"
-import "io"
 import "fmt"
 import "flag"
 import "sync"
-import "bufio"
+import "time"
 import "strings"
-import "strconv"
 import "net/url"
-import "net/http"
 import "github.com/montanaflynn/stats"
+import "github.com/prometheus/client_golang/prometheus"
 
 type cliOptions struct {
-	stdout      bool
-	maxLines    int
-	concurrency int
+	stdout             bool
+	maxLines           int
+	concurrency        int
+	requestTimeout     time.Duration
+	maxRetries         int
+	backoffBase        time.Duration
+	backoffMax         time.Duration
+	maxBodyBytes       int64
+	format             string
+	urlField           string
+	urlColumn          string
+	metricsAddr        string
+	output             string
+	checkpoint         string
+	resume             bool
+	human              bool
+	percentiles        string
+	perHostConcurrency int
+	rate               float64
+	ignoreRobots       bool
 }
 
 func main() {
@@ -23,6 +38,23 @@ func main() {
 	flag.BoolVar(&options.stdout, "stdout", true, "print results to stdout")
 	flag.IntVar(&options.maxLines, "max-lines", -1, "the maximum amount of lines to display")
 	flag.IntVar(&options.concurrency, "concurrency", 30, "the amount of in-flight requests to allow at a time")
+	flag.DurationVar(&options.requestTimeout, "request-timeout", 10*time.Second, "the per-request timeout")
+	flag.IntVar(&options.maxRetries, "max-retries", 3, "the maximum number of retry attempts for retryable failures")
+	flag.DurationVar(&options.backoffBase, "backoff-base", 200*time.Millisecond, "the base duration used for exponential backoff between retries")
+	flag.DurationVar(&options.backoffMax, "backoff-max", 10*time.Second, "the maximum duration to wait between retries")
+	flag.Int64Var(&options.maxBodyBytes, "max-body-bytes", 100*1024*1024, "the maximum response body size to read when measuring streamed sizes, 0 disables the cap")
+	flag.StringVar(&options.format, "format", "", "the input format (jsonl, lines, csv, sitemap), inferred from the file extension when empty")
+	flag.StringVar(&options.urlField, "url-field", "url", "the dotted field path containing the url in jsonl input")
+	flag.StringVar(&options.urlColumn, "url-column", "url", "the column name or 0-based index containing the url in csv input")
+	flag.StringVar(&options.metricsAddr, "metrics-addr", "", "if set, serve Prometheus metrics and a live /progress rollup on this address")
+	flag.StringVar(&options.output, "output", "", "if set, stream one NDJSON record per result to this file")
+	flag.StringVar(&options.checkpoint, "checkpoint", "", "if set, periodically flush the set of completed urls to this file")
+	flag.BoolVar(&options.resume, "resume", false, "seed completed urls from --checkpoint and append to --output instead of truncating")
+	flag.BoolVar(&options.human, "human", false, "format the summary's sizes as human-readable (KB/MB/GB) instead of raw bytes")
+	flag.StringVar(&options.percentiles, "percentiles", "50,90,95,99", "comma-separated list of size percentiles to report in the summary")
+	flag.IntVar(&options.perHostConcurrency, "per-host-concurrency", 0, "maximum in-flight requests per host, 0 means unlimited")
+	flag.Float64Var(&options.rate, "rate", 0, "maximum global requests per second across all hosts, 0 means unlimited")
+	flag.BoolVar(&options.ignoreRobots, "ignore-robots", false, "skip robots.txt crawl-delay and disallow checks")
 	flag.Parse()
 
 	if leftover := flag.Args(); len(leftover) != 1 {
@@ -53,25 +85,93 @@ func main() {
 		os.Exit(1)
 	}
 
+	decoder, e := selectDecoder(options.format, filename, options.urlField, options.urlColumn)
+
+	if e != nil {
+		fmt.Printf("could not select a decoder, found error: %v", e)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
 	fmt.Println("loading file")
 	duplicates := make(map[string]bool)
-	results := make(chan *ravenResult)
-	wg := &sync.WaitGroup{}
-	fetchers := make(chan *http.Client, options.concurrency)
 
-	for i := 0; i < options.concurrency; i++ {
-		fetchers <- &http.Client{}
+	var checkpoint *checkpointWriter
+	var checkpointStop chan struct{}
+
+	if options.checkpoint != "" {
+		checkpoint = newCheckpointWriter(options.checkpoint)
+
+		if options.resume {
+			seen, e := loadCheckpoint(options.checkpoint)
+
+			if e != nil && !os.IsNotExist(e) {
+				fmt.Printf("could not load checkpoint, found error: %v\n", e)
+				os.Exit(1)
+			}
+
+			for url := range seen {
+				duplicates[url] = true
+				checkpoint.completed[url] = true
+			}
+		}
+
+		checkpointStop = make(chan struct{})
+		go checkpoint.periodicFlush(5*time.Second, checkpointStop)
 	}
 
+	var output *ndjsonWriter
+
+	if options.output != "" {
+		openFlags := os.O_CREATE | os.O_WRONLY
+
+		if options.resume {
+			openFlags |= os.O_APPEND
+		} else {
+			openFlags |= os.O_TRUNC
+		}
+
+		file, e := os.OpenFile(options.output, openFlags, 0644)
+
+		if e != nil {
+			fmt.Printf("could not open output file, found error: %v\n", e)
+			os.Exit(1)
+		}
+
+		defer file.Close()
+		output = newNDJSONWriter(file)
+	}
+
+	results := make(chan *ravenResult)
+	wg := &sync.WaitGroup{}
+	dispatcher := newHostDispatcher(options.concurrency, options.perHostConcurrency, options.rate, options.ignoreRobots)
+
 	processor := resultProcessor{
-		results: results,
-		queue:   fetchers,
+		results:        results,
+		dispatcher:     dispatcher,
+		requestTimeout: options.requestTimeout,
+		maxRetries:     options.maxRetries,
+		backoffBase:    options.backoffBase,
+		backoffMax:     options.backoffMax,
+		maxBodyBytes:   options.maxBodyBytes,
 	}
 
 	semo := make(chan struct{})
 	rollup := flockMetrics{
-		sizes:     make([]float64, 0, 1e3),
-		ambiguous: make([]error, 0, 10),
+		sizes:       make([]float64, 0, 1e3),
+		ambiguous:   make([]error, 0, 10),
+		human:       options.human,
+		percentiles: parsePercentiles(options.percentiles),
+	}
+
+	progress := &progressState{rollup: &rollup}
+
+	if options.metricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		metrics := newRavenMetrics()
+		metrics.register(registry)
+		progress.metrics = metrics
+		serveMetrics(options.metricsAddr, registry, progress)
 	}
 
 	go func() {
@@ -80,7 +180,15 @@ func main() {
 		for raven := range results {
 			received += 1
 			fmt.Printf("%d received (%s)\n", received, raven.url)
-			rollup.add(raven)
+			progress.recordResult(raven)
+
+			if output != nil {
+				output.write(raven)
+			}
+
+			if checkpoint != nil && raven.exception == nil {
+				checkpoint.mark(raven.url)
+			}
 		}
 
 		semo <- struct{}{}
@@ -88,27 +196,35 @@ func main() {
 
 	leader := 0
 
-	for line := range iter(reader, options.maxLines) {
-		_, present := duplicates[line]
+	for line := range decoder.decode(reader, options.maxLines) {
 		leader += 1
+		progress.setLeader(leader)
 
-		if present {
-			fmt.Printf("%d duplicate %v\n", leader, line)
+		resource, e := url.Parse(line)
+
+		if e != nil {
+			fmt.Printf("line: %s had no valid url, skipping (e %v)\n", line, e)
 			continue
 		}
 
-		url, e := url.Parse(line)
+		// Key duplicates (and the checkpoint seeded above) by the
+		// canonicalized URL rather than the raw decoded line, so a line
+		// re-serializes to the same string the checkpoint/NDJSON output
+		// records it under and resume comparisons actually match.
+		key := resource.String()
+		_, present := duplicates[key]
 
-		if e != nil {
-			fmt.Printf("line: %s had no valid url, skipping (e %v)\n", line, e)
+		if present {
+			fmt.Printf("%d duplicate %v\n", leader, key)
 			continue
 		}
 
-		fmt.Printf("%d fetching %v\n", leader, url)
+		fmt.Printf("%d fetching %v\n", leader, resource)
 
-		duplicates[line] = true
+		duplicates[key] = true
+		progress.incrementDispatched()
 		wg.Add(1)
-		go processor.fetch(url, wg)
+		go processor.fetch(resource, wg)
 	}
 
 	reader.Close()
@@ -116,19 +232,33 @@ func main() {
 	close(results)
 	<-semo
 
+	if checkpoint != nil {
+		close(checkpointStop)
+
+		if e := checkpoint.flush(); e != nil {
+			fmt.Printf("could not flush checkpoint, found error: %v\n", e)
+		}
+	}
+
 	rollup.average = float64(rollup.sum) / float64(rollup.count)
 	fmt.Printf("done: %s\n", &rollup)
 }
 
 type flockMetrics struct {
-	failed    int
-	average   float64
-	sum       int
-	max       int
-	min       int
-	count     int
-	sizes     stats.Float64Data
-	ambiguous []error
+	failed         int
+	average        float64
+	sum            int
+	max            int
+	min            int
+	count          int
+	sizes          stats.Float64Data
+	ambiguous      []error
+	retried        int
+	retrySucceeded int
+	retryExhausted int
+	sizeMismatch   int
+	human          bool
+	percentiles    []float64
 }
 
 func (m *flockMetrics) add(raven *ravenResult) {
@@ -138,6 +268,20 @@ func (m *flockMetrics) add(raven *ravenResult) {
 		m.ambiguous = append(m.ambiguous, raven.exception)
 	}
 
+	if raven.sizeMismatch {
+		m.sizeMismatch += 1
+	}
+
+	if raven.retries > 0 {
+		m.retried += 1
+
+		if raven.exception == nil {
+			m.retrySucceeded += 1
+		} else {
+			m.retryExhausted += 1
+		}
+	}
+
 	if raven.exception != nil {
 		m.failed += 1
 		return
@@ -156,19 +300,21 @@ func (m *flockMetrics) add(raven *ravenResult) {
 }
 
 func (m *flockMetrics) String() string {
-	quarters, e := stats.Quartile(m.sizes)
+	formatSize := func(n float64) string {
+		if m.human {
+			return humanizeBytes(n)
+		}
 
-	if e != nil {
-		return fmt.Sprintf("invalid flock metrics, error: %v", e)
+		return fmt.Sprintf("%.2f", n)
 	}
 
-	ninenine, e := m.sizes.Percentile(99.0)
+	median, e := stats.Median(m.sizes)
 
 	if e != nil {
 		return fmt.Sprintf("invalid flock metrics, error: %v", e)
 	}
 
-	ninefive, e := m.sizes.Percentile(95.0)
+	stddev, e := stats.StandardDeviation(m.sizes)
 
 	if e != nil {
 		return fmt.Sprintf("invalid flock metrics, error: %v", e)
@@ -176,126 +322,46 @@ func (m *flockMetrics) String() string {
 
 	metrics := []string{
 		fmt.Sprintf("count[%d]", m.count),
-		fmt.Sprintf("max[%d]", m.max),
-		fmt.Sprintf("min[%d]", m.min),
-		fmt.Sprintf("avg[%f]", m.average),
-		fmt.Sprintf("quartiles[%v]", quarters),
-		fmt.Sprintf("95[%f]", ninefive),
-		fmt.Sprintf("99[%f]", ninenine),
+		fmt.Sprintf("max[%s]", formatSize(float64(m.max))),
+		fmt.Sprintf("min[%s]", formatSize(float64(m.min))),
+		fmt.Sprintf("avg[%s]", formatSize(m.average)),
+		fmt.Sprintf("median[%s]", formatSize(median)),
+		fmt.Sprintf("stddev[%s]", formatSize(stddev)),
 		fmt.Sprintf("failed[%d]", m.failed),
 		fmt.Sprintf("ambiguous[%d]", len(m.ambiguous)),
+		fmt.Sprintf("size-mismatch[%d]", m.sizeMismatch),
+		fmt.Sprintf("retried[%d]", m.retried),
+		fmt.Sprintf("retry-succeeded[%d]", m.retrySucceeded),
+		fmt.Sprintf("retry-exhausted[%d]", m.retryExhausted),
 	}
 
-	if len(m.ambiguous) > 0 {
-		listing := make([]string, len(m.ambiguous))
-
-		for i, e := range m.ambiguous {
-			listing[i] = fmt.Sprintf("%v\n", e)
-		}
+	percentiles := m.percentiles
 
-		metrics = append(metrics, fmt.Sprintf("\nAMBIGUOUS RAVENS:\n%s\n", strings.Join(listing, "")))
+	if len(percentiles) == 0 {
+		percentiles = []float64{50, 90, 95, 99}
 	}
 
-	return strings.Join(metrics, " ")
-}
-
-type resultProcessor struct {
-	results chan<- *ravenResult
-	queue   chan *http.Client
-}
+	for _, p := range percentiles {
+		value, e := m.sizes.Percentile(p)
 
-func (p *resultProcessor) fetch(resource *url.URL, group *sync.WaitGroup) {
-	defer group.Done()
-	client := <-p.queue
-	defer func() { p.queue <- client }()
-
-	response, e := client.Get(fmt.Sprintf("%v", resource))
-
-	if e != nil {
-		p.results <- &ravenResult{url: resource.String(), completed: true, exception: e}
-		return
-	}
-
-	defer response.Body.Close()
-
-	if response.StatusCode > 399 {
-		p.results <- &ravenResult{
-			url:       resource.String(),
-			completed: true,
-			exception: fmt.Errorf("invalid status code: %d", response.StatusCode),
-		}
-		return
-	}
-
-	value := response.Header.Get("Content-Length")
-
-	if len(value) <= 0 {
-		p.results <- &ravenResult{
-			url:       resource.String(),
-			completed: true,
-			exception: fmt.Errorf("no-content-length (status code %d): %s", response.StatusCode, resource),
-			ambiguous: true,
+		if e != nil {
+			continue
 		}
 
-		return
+		metrics = append(metrics, fmt.Sprintf("p%v[%s]", p, formatSize(value)))
 	}
 
-	length, e := strconv.Atoi(value)
+	if len(m.ambiguous) > 0 {
+		listing := make([]string, len(m.ambiguous))
 
-	if e != nil {
-		p.results <- &ravenResult{
-			url:       resource.String(),
-			completed: true,
-			exception: e,
-			ambiguous: true,
+		for i, e := range m.ambiguous {
+			listing[i] = fmt.Sprintf("%v\n", e)
 		}
 
-		return
-	}
-
-	p.results <- &ravenResult{
-		url:       resource.String(),
-		completed: true,
-		size:      length,
-		status:    response.StatusCode,
+		metrics = append(metrics, fmt.Sprintf("\nAMBIGUOUS RAVENS:\n%s\n", strings.Join(listing, "")))
 	}
-}
-
-type ravenResult struct {
-	url       string
-	completed bool
-	status    int
-	exception error
-	size      int
-	ambiguous bool
-}
-
-func iter(source io.Reader, max int) <-chan string {
-	out := make(chan string, 100)
-
-	go func() {
-		buffered := bufio.NewReader(source)
-		start := 0
 
-		for max < 0 || start < max {
-			s, e := buffered.ReadString('\n')
+	metrics = append(metrics, "\n"+sizeHistogram(m.sizes))
 
-			if e != nil {
-				break
-			}
-
-			if trimmed := strings.TrimSpace(s); !strings.HasPrefix(trimmed, "{") || !strings.HasSuffix(trimmed, "}") {
-				fmt.Printf("skipping line #%d\n", start)
-				continue
-			}
-
-			start = start + 1
-			cleansed := strings.Trim(s, "{\" \n}")
-			out <- cleansed
-		}
-
-		close(out)
-	}()
-
-	return out
+	return strings.Join(metrics, " ")
 }
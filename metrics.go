@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ravenMetrics holds the Prometheus collectors exposed on /metrics.
+type ravenMetrics struct {
+	issued       prometheus.Counter
+	completed    prometheus.Counter
+	failed       prometheus.Counter
+	ambiguous    prometheus.Counter
+	retried      prometheus.Counter
+	sizeMismatch prometheus.Counter
+	sizeHist     prometheus.Histogram
+	latencyHist  prometheus.Histogram
+}
+
+func newRavenMetrics() *ravenMetrics {
+	return &ravenMetrics{
+		issued: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "raven_requests_issued_total",
+			Help: "total number of requests issued",
+		}),
+		completed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "raven_requests_completed_total",
+			Help: "total number of requests that completed without error",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "raven_requests_failed_total",
+			Help: "total number of requests that failed",
+		}),
+		ambiguous: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "raven_requests_ambiguous_total",
+			Help: "total number of requests with an ambiguous outcome",
+		}),
+		retried: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "raven_requests_retried_total",
+			Help: "total number of requests that required at least one retry",
+		}),
+		sizeMismatch: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "raven_requests_size_mismatch_total",
+			Help: "total number of requests whose measured body size disagreed with the declared Content-Length",
+		}),
+		sizeHist: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "raven_response_size_bytes",
+			Help:    "distribution of measured response sizes",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 12),
+		}),
+		latencyHist: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "raven_request_latency_seconds",
+			Help:    "distribution of request latency, including retries",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+}
+
+func (m *ravenMetrics) register(registry *prometheus.Registry) {
+	registry.MustRegister(m.issued, m.completed, m.failed, m.ambiguous, m.retried, m.sizeMismatch, m.sizeHist, m.latencyHist)
+}
+
+func (m *ravenMetrics) observe(raven *ravenResult) {
+	m.issued.Inc()
+
+	if raven.retries > 0 {
+		m.retried.Inc()
+	}
+
+	if raven.ambiguous {
+		m.ambiguous.Inc()
+	}
+
+	if raven.sizeMismatch {
+		m.sizeMismatch.Inc()
+	}
+
+	if raven.exception != nil {
+		m.failed.Inc()
+		return
+	}
+
+	m.completed.Inc()
+	m.sizeHist.Observe(float64(raven.size))
+	m.latencyHist.Observe(raven.latency.Seconds())
+}
+
+// progressState is the thread-safe rollup backing the /progress endpoint: a
+// live view of the crawl's leader position and flockMetrics while it runs.
+type progressState struct {
+	mutex      sync.Mutex
+	leader     int
+	dispatched int
+	rollup     *flockMetrics
+	metrics    *ravenMetrics
+}
+
+func (s *progressState) setLeader(leader int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.leader = leader
+}
+
+// incrementDispatched marks that another fetch has actually been handed to
+// a worker goroutine, as distinct from setLeader's count of decoded lines
+// (which also advances for duplicates and unparseable urls that never
+// dispatch).
+func (s *progressState) incrementDispatched() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dispatched += 1
+}
+
+func (s *progressState) recordResult(raven *ravenResult) {
+	s.mutex.Lock()
+	s.rollup.add(raven)
+	s.mutex.Unlock()
+
+	if s.metrics != nil {
+		s.metrics.observe(raven)
+	}
+}
+
+func (s *progressState) snapshot() progressSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return progressSnapshot{
+		Leader:    s.leader,
+		InFlight:  s.dispatched - s.rollup.count,
+		Completed: s.rollup.count,
+		Failed:    s.rollup.failed,
+		Ambiguous: len(s.rollup.ambiguous),
+		Retried:   s.rollup.retried,
+	}
+}
+
+type progressSnapshot struct {
+	Leader    int `json:"leader"`
+	InFlight  int `json:"in_flight"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Ambiguous int `json:"ambiguous"`
+	Retried   int `json:"retried"`
+}
+
+// serveMetrics starts a background HTTP server at addr exposing Prometheus
+// metrics at /metrics and a live JSON rollup at /progress. A failure to
+// bind is logged rather than fatal, since metrics are optional.
+func serveMetrics(addr string, registry *prometheus.Registry, progress *progressState) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/progress", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(progress.snapshot())
+	})
+
+	go func() {
+		if e := http.ListenAndServe(addr, mux); e != nil {
+			fmt.Printf("metrics server stopped: %v\n", e)
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// ndjsonWriter streams one JSON object per ravenResult to an underlying
+// file so long runs keep a durable record even if the process crashes.
+type ndjsonWriter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func newNDJSONWriter(file *os.File) *ndjsonWriter {
+	return &ndjsonWriter{encoder: json.NewEncoder(file)}
+}
+
+type ndjsonRecord struct {
+	URL          string `json:"url"`
+	Status       int    `json:"status"`
+	Size         int    `json:"size"`
+	HeaderSize   int    `json:"header_size"`
+	MeasuredSize int    `json:"measured_size"`
+	SizeMismatch bool   `json:"size_mismatch,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Retries      int    `json:"retries"`
+	LatencyMs    int64  `json:"latency_ms"`
+	Ambiguous    bool   `json:"ambiguous"`
+}
+
+func (w *ndjsonWriter) write(raven *ravenResult) error {
+	record := ndjsonRecord{
+		URL:          raven.url,
+		Status:       raven.status,
+		Size:         raven.size,
+		HeaderSize:   raven.headerSize,
+		MeasuredSize: raven.measuredSize,
+		SizeMismatch: raven.sizeMismatch,
+		Retries:      raven.retries,
+		LatencyMs:    raven.latency.Milliseconds(),
+		Ambiguous:    raven.ambiguous,
+	}
+
+	if raven.exception != nil {
+		record.Error = raven.exception.Error()
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.encoder.Encode(record)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// tokenBucket is a simple blocking QPS limiter: take() blocks until a token
+// refills. A non-positive rate disables limiting entirely.
+type tokenBucket struct {
+	tokens chan struct{}
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return &tokenBucket{}
+	}
+
+	bucket := &tokenBucket{tokens: make(chan struct{}, 1)}
+	interval := time.Duration(float64(time.Second) / rate)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			select {
+			case bucket.tokens <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return bucket
+}
+
+func (b *tokenBucket) take() {
+	if b.tokens == nil {
+		return
+	}
+
+	<-b.tokens
+}